@@ -15,12 +15,17 @@ Requires: ffmpeg, ffprobe
 from __future__ import annotations
 
 import argparse
+import array
+import cmath
 import json
+import math
+import os
 import re
 import shutil
 import subprocess
 import sys
-from dataclasses import dataclass
+from concurrent.futures import ThreadPoolExecutor
+from dataclasses import dataclass, field
 from pathlib import Path
 from typing import Any, Dict, List, Optional, Tuple
 
@@ -29,28 +34,137 @@ from typing import Any, Dict, List, Optional, Tuple
 # Helpers
 # ----------------------------
 
-def die(msg: str, code: int = 1) -> None:
-    print(f"ERROR: {msg}", file=sys.stderr)
-    raise SystemExit(code)
+class QCError(Exception):
+    """A run-level error (as opposed to a file failing QC). main() prints it and exits with exit_code."""
+    exit_code = 1
 
-def which_or_die(bin_name: str) -> str:
+class ToolError(QCError):
+    """ffmpeg/ffprobe missing or unusable."""
+    exit_code = 3
+
+class ConfigError(QCError):
+    """Config or report JSON unreadable."""
+    exit_code = 4
+
+class InputError(QCError):
+    """Input path missing or holding no audio files."""
+    exit_code = 5
+
+# argparse exits 2 on usage errors, which is also the "a file failed QC"
+# code; use EX_USAGE from sysexits.h so scripts can tell them apart.
+USAGE_EXIT_CODE = 64
+
+class QCArgumentParser(argparse.ArgumentParser):
+    def error(self, message: str) -> None:
+        self.print_usage(sys.stderr)
+        self.exit(USAGE_EXIT_CODE, f"{self.prog}: error: {message}\n")
+
+def require_tool(bin_name: str) -> str:
     p = shutil.which(bin_name)
     if not p:
-        die(f"Missing required tool '{bin_name}' on PATH.")
+        raise ToolError(f"Missing required tool '{bin_name}' on PATH.")
     return p
 
+# Upper bound for a single ffmpeg/ffprobe invocation, so a hung decoder can't
+# stall a whole batch. Set from --timeout; None disables it.
+RUN_TIMEOUT_S: Optional[float] = None
+
+def run_raw(cmd: List[str]) -> Tuple[int, bytes, str]:
+    """Like run(), but returns stdout as bytes (for decoded PCM)."""
+    try:
+        proc = subprocess.run(cmd, stdout=subprocess.PIPE, stderr=subprocess.PIPE, timeout=RUN_TIMEOUT_S)
+    except subprocess.TimeoutExpired:
+        return 124, b"", f"{Path(cmd[0]).name} timed out after {RUN_TIMEOUT_S:g}s"
+    return proc.returncode, proc.stdout, proc.stderr.decode("utf-8", "replace")
+
 def run(cmd: List[str]) -> Tuple[int, str, str]:
-    proc = subprocess.run(cmd, stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True)
+    try:
+        proc = subprocess.run(cmd, stdout=subprocess.PIPE, stderr=subprocess.PIPE, text=True, timeout=RUN_TIMEOUT_S)
+    except subprocess.TimeoutExpired:
+        # Same code as coreutils timeout(1); callers treat it as a failed run.
+        return 124, "", f"{Path(cmd[0]).name} timed out after {RUN_TIMEOUT_S:g}s"
     return proc.returncode, proc.stdout, proc.stderr
 
-def load_json(path: Path) -> Dict[str, Any]:
+def tool_error(cmd: List[str], rc: int, err: str) -> str:
+    """Short description of a failed run: exit code and the last stderr line."""
+    lines = [l for l in err.strip().splitlines() if l.strip()]
+    return f"{Path(cmd[0]).name} exited with {rc}" + (f": {lines[-1].strip()}" if lines else "")
+
+def tool_version(bin_path: str) -> str:
+    """
+    First line of `<tool> -version`, e.g. "ffmpeg version 6.1.1 ...", reduced
+    to the version token. Raises ToolError if the tool can't run at all.
+    """
+    code, out, err = run([bin_path, "-version"])
+    first = (out or err).strip().splitlines()[:1]
+    if code != 0 or not first:
+        raise ToolError(f"'{bin_path} -version' failed: {err.strip() or 'no output'}")
+    m = re.match(r"\S+ version (\S+)", first[0])
+    return m.group(1) if m else first[0]
+
+def load_json(path: Path) -> Any:
     try:
         return json.loads(path.read_text(encoding="utf-8"))
     except Exception as e:
-        die(f"Failed to read config JSON: {path} ({e})")
+        raise ConfigError(f"Failed to read JSON: {path} ({e})")
+
+def load_config(path: Path) -> Dict[str, Any]:
+    """Reads qc_config.json and checks the blocks every run needs."""
+    config = load_json(path)
+    if not isinstance(config, dict):
+        raise ConfigError(f"Config must be a JSON object: {path}")
+    for key in ("expected", "masters"):
+        if not isinstance(config.get(key), dict):
+            raise ConfigError(f"Config is missing the '{key}' object: {path}")
+    for key in ("naming", "report", "low_end_stereo", "metadata", "silence", "lossy_source",
+                "resample_detection", "dual_mono", "release"):
+        if key in config and not isinstance(config[key], dict):
+            raise ConfigError(f"Config '{key}' must be an object: {path}")
+    for name, mcfg in config["masters"].items():
+        if not isinstance(mcfg, dict):
+            raise ConfigError(f"Config masters['{name}'] must be an object: {path}")
+    return config
+
+# Container formats (as named by ffprobe's format_name) the QC accepts, with
+# their file extensions and the codec (substring) a lossless master must use.
+FORMAT_EXTENSIONS = {
+    "wav": [".wav"],
+    "aiff": [".aif", ".aiff"],
+    "flac": [".flac"],
+}
+FORMAT_CODECS = {"flac": "flac"}  # others fall back to expected.codec_contains
+
+def sniff_container(path: Path) -> Optional[str]:
+    """
+    Identifies the container from its magic bytes, so non-audio files are
+    rejected before ffmpeg ever parses them. RF64/BW64 are the >4 GB WAV variants.
+    """
+    with path.open("rb") as f:
+        head = f.read(12)
+    if head[:4] in (b"RIFF", b"RF64", b"BW64") and head[8:12] == b"WAVE":
+        return "wav"
+    if head[:4] == b"FORM" and head[8:12] in (b"AIFF", b"AIFC"):
+        return "aiff"
+    if head[:4] == b"fLaC":
+        return "flac"
+    return None
 
-def is_wav(path: Path) -> bool:
-    return path.suffix.lower() == ".wav"
+def allowed_formats(expected: Dict[str, Any], master_cfg: Dict[str, Any]) -> List[str]:
+    default = expected.get("formats_allowed", [expected.get("format", "wav")])
+    return [f.lower() for f in master_cfg.get("formats_allowed", default)]
+
+def extensions_for(formats: List[str]) -> List[str]:
+    return [e for f in formats for e in FORMAT_EXTENSIONS.get(f, [])]
+
+def parse_number(s: str) -> Optional[float]:
+    """
+    Parses a number as printed by ffmpeg. Some builds honour the system locale and
+    print ',' as the decimal separator, so accept both.
+    """
+    try:
+        return float(s.strip().replace(",", "."))
+    except Exception:
+        return None
 
 def pretty(x: Any) -> str:
     if x is None:
@@ -72,23 +186,75 @@ class AudioInfo:
     sample_rate_hz: Optional[int] = None
     bit_depth: Optional[int] = None
     channels: Optional[int] = None
+    channel_layout: Optional[str] = None  # ffprobe name: mono, stereo, 5.1, ...
     duration_s: Optional[float] = None
 
 @dataclass
 class LoudnessInfo:
     integrated_lufs: Optional[float] = None
     true_peak_db: Optional[float] = None
+    loudness_range_lu: Optional[float] = None
+    max_momentary_lufs: Optional[float] = None   # 400 ms window
+    max_short_term_lufs: Optional[float] = None  # 3 s window
+    error: Optional[str] = None  # set when the analysis could not run
 
 @dataclass
 class LowEndStereoInfo:
     mid_rms_db: Optional[float] = None
     side_rms_db: Optional[float] = None
     side_minus_mid_db: Optional[float] = None  # side - mid (should be <= -threshold)
+    error: Optional[str] = None
+
+@dataclass
+class SignalStats:
+    clip_count: Optional[int] = None  # full-scale clip events (runs, not samples), all channels
+    dc_offset: Optional[float] = None  # max |DC offset| across channels (linear)
+    sample_peak_db: Optional[float] = None  # max sample peak, dBFS (not inter-sample)
+    effective_bit_depth: Optional[int] = None  # bits actually used, max across channels
+    rms_balance_db: Optional[float] = None  # |L - R| RMS level, stereo only
+    error: Optional[str] = None
+
+@dataclass
+class StereoInfo:
+    phase_correlation: Optional[float] = None      # mean L/R correlation, -1..+1
+    phase_correlation_min: Optional[float] = None  # worst single frame
+    dual_mono: Optional[bool] = None  # L and R carry the same signal
+    error: Optional[str] = None
+
+@dataclass
+class SpectrumInfo:
+    cutoff_hz: Optional[float] = None  # brick-wall edge, None if none found
+    suspected_lossy: bool = False
+    suspected_resample: bool = False
+    resample_from_hz: Optional[int] = None  # likely original sample rate
+    error: Optional[str] = None
+
+@dataclass
+class SilenceInfo:
+    leading_ms: Optional[float] = None
+    trailing_ms: Optional[float] = None
+    error: Optional[str] = None
 
 @dataclass
 class ArtworkInfo:
     has_embedded_artwork: bool
     details: str
+    error: Optional[str] = None
+
+@dataclass
+class MetadataInfo:
+    tags: Dict[str, str]      # lower-cased tag name -> value
+    present: Dict[str, bool]  # logical field (artist, title, ...) -> found
+    isrc: Optional[str] = None
+    error: Optional[str] = None
+
+@dataclass
+class NormalizationInfo:
+    target_lufs: float
+    gain_db: float                 # gain that would land on target_lufs
+    gain_linear: float
+    predicted_true_peak_db: Optional[float]  # measured TP + gain (linear gain scales TP 1:1)
+    true_peak_max_db: Optional[float]        # the master's TP limit, if any
 
 @dataclass
 class QCResult:
@@ -97,40 +263,72 @@ class QCResult:
     audio: AudioInfo
     loudness: LoudnessInfo
     low_end: LowEndStereoInfo
+    signal: SignalStats
+    stereo: StereoInfo
+    spectrum: SpectrumInfo
+    silence: SilenceInfo
     artwork: ArtworkInfo
+    metadata: MetadataInfo
     checks: List[Dict[str, Any]]
-    passed: bool
+    passed: bool            # no blocking check failed
+    warnings: List[str]     # failed advisory checks, "id: details"
+    spectrogram: Optional[Path] = None
+    tagged_copy: Optional[Path] = None
+    analysis_errors: Dict[str, str] = field(default_factory=dict)  # analysis -> why it failed
+    normalization: Optional[NormalizationInfo] = None
 
 
 # ----------------------------
 # Probing
 # ----------------------------
 
-def ffprobe_audio_info(ffprobe_bin: str, path: Path) -> AudioInfo:
+NUM_RE = r"[-+]?\d+(?:[.,]\d+)?"
+SILENCE_FLOOR_DB = -120.0
+
+def ebur128_summary(stderr: str) -> str:
+    """
+    Returns the trailing "Summary:" block printed by the ebur128 filter.
+    Per-frame log lines also carry an "I:" value (the running integrated
+    loudness), so values must only be read from the summary.
+    """
+    idx = stderr.rfind("Summary:")
+    return stderr[idx:] if idx >= 0 else ""
+
+def ffprobe_audio_info(ffprobe_bin: str, path: Path) -> Optional[AudioInfo]:
+    """
+    Probes the first audio stream. Returns None when ffprobe can't parse the
+    file or finds no audio stream (corrupt or truncated upload).
+    """
     cmd = [
         ffprobe_bin,
         "-v", "error",
         "-select_streams", "a:0",
         "-show_entries",
-        "format=format_name,duration:stream=codec_name,sample_rate,channels,bits_per_raw_sample,bits_per_sample",
+        "format=format_name,duration:stream=codec_name,sample_rate,channels,channel_layout,bits_per_raw_sample,bits_per_sample",
         "-of", "json",
         str(path),
     ]
     rc, out, err = run(cmd)
     if rc != 0:
-        die(f"ffprobe failed on {path.name}: {err.strip()}")
-
-    data = json.loads(out)
+        return None
+    try:
+        data = json.loads(out)
+    except ValueError:
+        return None
     fmt = data.get("format", {})
     streams = data.get("streams", [])
-    s0 = streams[0] if streams else {}
+    if not streams:
+        return None
+    s0 = streams[0]
 
     bprs = s0.get("bits_per_raw_sample")
     bps = s0.get("bits_per_sample")
+    # ffprobe reports 0 when a field doesn't apply (e.g. bits_per_sample for
+    # FLAC, both fields for lossy codecs), so only accept positive values.
     bit_depth = None
     for v in (bprs, bps):
         try:
-            if v is not None and str(v).strip() != "":
+            if v is not None and str(v).strip() != "" and int(v) > 0:
                 bit_depth = int(v)
                 break
         except Exception:
@@ -164,50 +362,190 @@ def ffprobe_audio_info(ffprobe_bin: str, path: Path) -> AudioInfo:
         sample_rate_hz=sample_rate,
         bit_depth=bit_depth,
         channels=channels,
+        channel_layout=s0.get("channel_layout") or None,
         duration_s=duration,
     )
 
 def ffmpeg_loudness(ffmpeg_bin: str, path: Path) -> LoudnessInfo:
+    """
+    Single ebur128 pass with peak=true: integrated LUFS, loudness range and
+    true peak are all read from the summary. The summary "Peak:" is already the maximum across
+    channels.
+    """
     integrated_lufs = None
     true_peak_db = None
 
-    # Integrated LUFS via ebur128
-    cmd_i = [
+    cmd = [
         ffmpeg_bin,
         "-hide_banner",
         "-nostats",
         "-i", str(path),
-        "-filter_complex", "ebur128=framelog=verbose",
+        "-filter_complex", "ebur128=framelog=info:peak=true",
         "-f", "null", "-"
     ]
-    rc, out, err = run(cmd_i)
+    rc, out, err = run(cmd)
+    if rc != 0:
+        return LoudnessInfo(error=tool_error(cmd, rc, err))
 
-    m = re.findall(r"\bI:\s*([-+]?\d+(\.\d+)?)\s*LUFS\b", err)
+    summary = ebur128_summary(err)
+    m = re.search(rf"\bI:\s*({NUM_RE})\s*LUFS\b", summary)
     if m:
-        try:
-            integrated_lufs = float(m[-1][0])
-        except Exception:
-            integrated_lufs = None
+        integrated_lufs = parse_number(m.group(1))
+
+    loudness_range_lu = None
+    m_lra = re.search(rf"\bLRA:\s*({NUM_RE})\s*LU\b", summary)
+    if m_lra:
+        loudness_range_lu = parse_number(m_lra.group(1))
+
+    # The summary has no M/S maxima; take them from the per-frame log lines,
+    # which sit before the summary. framelog=info logs them at ffmpeg's
+    # default loglevel; framelog=verbose would need -v verbose as well.
+    frames = err[: len(err) - len(summary)]
+    momentary = [parse_number(v) for v in re.findall(rf"\bM:\s*({NUM_RE})", frames)]
+    short_term = [parse_number(v) for v in re.findall(rf"\bS:\s*({NUM_RE})", frames)]
+    max_momentary_lufs = max((v for v in momentary if v is not None), default=None)
+    max_short_term_lufs = max((v for v in short_term if v is not None), default=None)
+
+    m2 = re.search(rf"True peak:\s*Peak:\s*({NUM_RE}|-inf)\s*dB", summary, flags=re.IGNORECASE)
+    if m2:
+        # Digital silence reports -inf; store a floor instead of failing the parse.
+        if m2.group(1).lower() == "-inf":
+            true_peak_db = SILENCE_FLOOR_DB
+        else:
+            true_peak_db = parse_number(m2.group(1))
+
+    return LoudnessInfo(
+        integrated_lufs=integrated_lufs,
+        true_peak_db=true_peak_db,
+        loudness_range_lu=loudness_range_lu,
+        max_momentary_lufs=max_momentary_lufs,
+        max_short_term_lufs=max_short_term_lufs,
+    )
+
+ASTATS_LINE_RE = re.compile(r"^\[Parsed_astats_(\d+) @ [^\]]+\]\s*(.*?)\s*$")
+
+# A 24-bit file peaks at -0.000001 dBFS and a 16-bit one at -0.00027 dBFS, so
+# anything at or above this is sitting on the rails.
+FULL_SCALE_DB = -0.001
 
-    # True peak via loudnorm measurement
-    cmd_tp = [
+def parse_astats(stderr: str) -> Dict[int, Dict[str, Any]]:
+    """
+    Parses the end-of-stream report of every astats instance in a graph into
+      {filter_index: {"channels": [{key: value}, ...], "overall": {key: value}}}
+    keyed by the N in "Parsed_astats_N". Values are left as strings; read them
+    with astats_number().
+    """
+    sections: Dict[int, Dict[str, Any]] = {}
+    current: Dict[int, Dict[str, str]] = {}
+    for line in stderr.splitlines():
+        m = ASTATS_LINE_RE.match(line.strip())
+        if not m:
+            continue
+        idx, body = int(m.group(1)), m.group(2)
+        inst = sections.setdefault(idx, {"channels": [], "overall": {}})
+        if body.startswith("Channel:"):
+            current[idx] = {}
+            inst["channels"].append(current[idx])
+        elif body == "Overall":
+            current[idx] = inst["overall"]
+        elif ":" in body and idx in current:
+            k, v = body.split(":", 1)
+            current[idx][k.strip()] = v.strip()
+    return sections
+
+def astats_number(v: Optional[str]) -> Optional[float]:
+    if v is None:
+        return None
+    if v.lower() == "-inf":
+        return SILENCE_FLOOR_DB
+    return parse_number(v.split("/")[0])
+
+def ffmpeg_signal_stats(ffmpeg_bin: str, path: Path) -> SignalStats:
+    """
+    Full-band astats pass over the untouched signal. astats has no clip
+    counter, so a channel counts as clipped when its peak sits at full scale;
+    its "Peak count" is then the number of clip events. astats counts
+    occasions the min/max level is reached, not samples, so a flat-topped
+    run of clipped samples is one event.
+    """
+    cmd = [
         ffmpeg_bin,
         "-hide_banner",
         "-nostats",
         "-i", str(path),
-        "-af", "loudnorm=I=-16:TP=-1.5:LRA=11:print_format=json",
+        "-af", "astats=metadata=0",
         "-f", "null", "-"
     ]
-    rc2, out2, err2 = run(cmd_tp)
+    rc, out, err = run(cmd)
+    if rc != 0:
+        return SignalStats(error=tool_error(cmd, rc, err))
+
+    sections = parse_astats(err)
+    if not sections:
+        return SignalStats()
+    channels = sections[min(sections)]["channels"]
+
+    clip_count = 0
+    dc_offset = None
+    sample_peak_db = None
+    effective_bit_depth = None
+    rms_levels = []
+    for ch in channels:
+        # "Bit depth: used/format", e.g. 16/32 for 16-bit audio padded into a
+        # 24-bit file (decoded as s32).
+        bd = re.match(r"(\d+)/\d+", ch.get("Bit depth", ""))
+        if bd:
+            effective_bit_depth = max(int(bd.group(1)), effective_bit_depth or 0)
+        peak_db = astats_number(ch.get("Peak level dB"))
+        if peak_db is not None:
+            sample_peak_db = peak_db if sample_peak_db is None else max(sample_peak_db, peak_db)
+        if peak_db is not None and peak_db >= FULL_SCALE_DB:
+            clip_count += int(astats_number(ch.get("Peak count")) or 0)
+        dc = astats_number(ch.get("DC offset"))
+        if dc is not None:
+            dc_offset = max(abs(dc), dc_offset or 0.0)
+        rms_levels.append(astats_number(ch.get("RMS level dB")))
+
+    rms_balance_db = None
+    if len(rms_levels) == 2 and None not in rms_levels:
+        rms_balance_db = abs(rms_levels[0] - rms_levels[1])
+
+    return SignalStats(
+        clip_count=clip_count,
+        dc_offset=dc_offset,
+        sample_peak_db=sample_peak_db,
+        effective_bit_depth=effective_bit_depth,
+        rms_balance_db=rms_balance_db,
+    )
 
-    m2 = re.search(r'"measured_TP"\s*:\s*"([-+]?\d+(\.\d+)?)"', err2)
-    if m2:
-        try:
-            true_peak_db = float(m2.group(1))
-        except Exception:
-            true_peak_db = None
+def ffmpeg_phase_correlation(ffmpeg_bin: str, path: Path) -> StereoInfo:
+    """
+    L/R phase correlation from aphasemeter's per-frame metadata, logged by
+    ametadata. +1 means L and R are identical (folds to mono losslessly),
+    0 means uncorrelated/wide, negative means out of phase (cancels in mono).
+    Only meaningful for stereo input.
+    """
+    cmd = [
+        ffmpeg_bin,
+        "-hide_banner",
+        "-nostats",
+        "-i", str(path),
+        "-af", "aphasemeter=video=0,ametadata=mode=print:key=lavfi.aphasemeter.phase",
+        "-f", "null", "-"
+    ]
+    rc, out, err = run(cmd)
+    if rc != 0:
+        return StereoInfo(error=tool_error(cmd, rc, err))
+
+    vals = []
+    for m in re.findall(rf"lavfi\.aphasemeter\.phase=({NUM_RE})", err):
+        v = parse_number(m)
+        if v is not None:
+            vals.append(v)
+    if not vals:
+        return StereoInfo()
 
-    return LoudnessInfo(integrated_lufs=integrated_lufs, true_peak_db=true_peak_db)
+    return StereoInfo(phase_correlation=sum(vals) / len(vals), phase_correlation_min=min(vals))
 
 def ffmpeg_low_end_mid_side_rms(ffmpeg_bin: str, path: Path, cutoff_hz: int) -> LowEndStereoInfo:
     """
@@ -239,7 +577,7 @@ def ffmpeg_low_end_mid_side_rms(ffmpeg_bin: str, path: Path, cutoff_hz: int) ->
     rc, out, err = run(cmd)
     if rc != 0:
         # Don't hard-die; return unknown and fail the check upstream.
-        return LowEndStereoInfo(None, None, None)
+        return LowEndStereoInfo(error=tool_error(cmd, rc, err))
 
     # Parse astats RMS level dB. We expect two sequences.
     # We'll pull all RMS dB values, then heuristically split into two buckets by order.
@@ -273,6 +611,217 @@ def ffmpeg_low_end_mid_side_rms(ffmpeg_bin: str, path: Path, cutoff_hz: int) ->
 
     return LowEndStereoInfo(mid_rms_db=mid_rms, side_rms_db=side_rms, side_minus_mid_db=side_minus_mid)
 
+def ffmpeg_silence(ffmpeg_bin: str, path: Path, duration_s: Optional[float], silence_cfg: Dict[str, Any]) -> SilenceInfo:
+    """
+    Leading/trailing silence via silencedetect. silence.threshold_db sets what
+    counts as silence: a dB level (e.g. -60 for near-silence) or "digital" for
+    exact zero samples only.
+    """
+    threshold = silence_cfg.get("threshold_db", -60.0)
+    # silencedetect tests |sample| < noise, so 0 would never match; 1e-9
+    # (-180 dB) sits below the LSB of any PCM format.
+    noise = "0.000000001" if str(threshold).lower() == "digital" else f"{float(threshold)}dB"
+    min_dur = float(silence_cfg.get("min_duration_s", 0.05))
+
+    cmd = [
+        ffmpeg_bin,
+        "-hide_banner",
+        "-nostats",
+        "-i", str(path),
+        "-af", f"silencedetect=noise={noise}:d={min_dur}",
+        "-f", "null", "-"
+    ]
+    rc, out, err = run(cmd)
+    if rc != 0:
+        return SilenceInfo(error=tool_error(cmd, rc, err))
+
+    starts = [parse_number(v) for v in re.findall(rf"silence_start:\s*({NUM_RE})", err)]
+    ends = [parse_number(v) for v in re.findall(rf"silence_end:\s*({NUM_RE})", err)]
+
+    leading = 0.0
+    if starts and starts[0] is not None and starts[0] <= 0.001 and ends and ends[0] is not None:
+        leading = ends[0] * 1000.0
+
+    # A silence running into EOF has no silence_end on older ffmpeg builds
+    # and one at (roughly) the duration on newer ones.
+    trailing = 0.0
+    if starts and starts[-1] is not None and duration_s is not None:
+        last_end = ends[len(starts) - 1] if len(ends) >= len(starts) else None
+        if last_end is None or last_end >= duration_s - 0.01:
+            trailing = max(0.0, duration_s - starts[-1]) * 1000.0
+
+    return SilenceInfo(leading_ms=leading, trailing_ms=trailing)
+
+FFT_SIZE = 8192
+SPECTRUM_FRAMES = 32
+SPECTRUM_BAND_HZ = 250.0
+
+def fft(x: List[complex]) -> List[complex]:
+    """In-place iterative radix-2 FFT; len(x) must be a power of two."""
+    n = len(x)
+    j = 0
+    for i in range(1, n):
+        bit = n >> 1
+        while j & bit:
+            j ^= bit
+            bit >>= 1
+        j |= bit
+        if i < j:
+            x[i], x[j] = x[j], x[i]
+    size = 2
+    while size <= n:
+        w_step = cmath.exp(-2j * math.pi / size)
+        half = size // 2
+        for start in range(0, n, size):
+            w = 1 + 0j
+            for k in range(start, start + half):
+                t = w * x[k + half]
+                x[k + half] = x[k] - t
+                x[k] = x[k] + t
+                w *= w_step
+        size *= 2
+    return x
+
+def ffmpeg_band_spectrum(ffmpeg_bin: str, path: Path, sample_rate: int, duration_s: Optional[float]) -> Tuple[List[float], float]:
+    """
+    Average power spectrum (dB) of a mono fold-down, in roughly
+    SPECTRUM_BAND_HZ wide bands from 0 Hz to Nyquist. Returns the band levels
+    and the exact band width in Hz. A 30 s window from the body of the track is
+    decoded (intros/outros are often filtered), then SPECTRUM_FRAMES
+    Hann-windowed frames spread across it are averaged.
+
+    Pure-Python FFT keeps the script dependency-free; 32 frames of 8192
+    points take a few seconds.
+    """
+    start = max(0.0, (duration_s or 0.0) * 0.3)
+    cmd = [
+        ffmpeg_bin,
+        "-hide_banner",
+        "-nostats",
+        "-v", "error",
+        "-ss", f"{start:.3f}",
+        "-t", "30",
+        "-i", str(path),
+        "-ac", "1",
+        "-f", "f32le", "-acodec", "pcm_f32le",
+        "-"
+    ]
+    rc, out, err = run_raw(cmd)
+    if rc != 0:
+        return [], 0.0
+
+    samples = array.array("f")
+    samples.frombytes(out[: len(out) - len(out) % 4])
+    if sys.byteorder == "big":
+        samples.byteswap()
+    if len(samples) < FFT_SIZE:
+        return [], 0.0
+
+    window = [0.5 - 0.5 * math.cos(2 * math.pi * i / (FFT_SIZE - 1)) for i in range(FFT_SIZE)]
+    hop = max(FFT_SIZE, (len(samples) - FFT_SIZE) // SPECTRUM_FRAMES)
+    power = [0.0] * (FFT_SIZE // 2)
+    frames = 0
+    for off in range(0, len(samples) - FFT_SIZE + 1, hop):
+        spec = fft([complex(samples[off + i] * window[i]) for i in range(FFT_SIZE)])
+        for b in range(FFT_SIZE // 2):
+            power[b] += abs(spec[b]) ** 2
+        frames += 1
+        if frames >= SPECTRUM_FRAMES:
+            break
+
+    bin_hz = sample_rate / FFT_SIZE
+    per_band = max(1, int(SPECTRUM_BAND_HZ / bin_hz))
+    bands = []
+    for b in range(0, FFT_SIZE // 2 - per_band + 1, per_band):
+        p = sum(power[b:b + per_band]) / (per_band * frames)
+        bands.append(10 * math.log10(p) if p > 0 else SILENCE_FLOOR_DB)
+    return bands, per_band * bin_hz
+
+def find_spectral_cutoff(bands: List[float], band_hz: float, from_hz: float, min_drop_db: float) -> Optional[float]:
+    """
+    Lowest band edge at or above from_hz where the 2 kHz below it is at
+    least min_drop_db louder than anything above it (up to just short of
+    Nyquist). A lossy encoder's lowpass shows up as exactly that wall.
+    """
+    if not bands or band_hz <= 0:
+        return None
+    below_n = max(1, int(2000 / band_hz))
+    top = len(bands) - 1  # skip the band that holds the anti-alias rolloff
+    for k in range(max(below_n, int(from_hz / band_hz)), top):
+        below = sum(bands[k - below_n:k]) / below_n
+        above = max(bands[k:top])
+        if below - above >= min_drop_db:
+            return k * band_hz
+    return None
+
+SPECTROGRAM_MIN = (320, 240)
+SPECTROGRAM_MAX = (4096, 2048)
+
+def ffmpeg_spectrogram(ffmpeg_bin: str, path: Path, rel: Path, out_dir: Path, width: int, height: int) -> Optional[Path]:
+    """
+    Renders a showspectrumpic PNG next to the reports, at `rel` (the file's
+    path relative to the scan root) under out_dir, so same-named files in
+    different folders or with different extensions get their own image.
+    A "<png>.src" sidecar records the audio file's size and mtime; while both
+    match, the existing image is reused, so re-running QC on a folder is cheap.
+    """
+    w = min(max(int(width), SPECTROGRAM_MIN[0]), SPECTROGRAM_MAX[0])
+    h = min(max(int(height), SPECTROGRAM_MIN[1]), SPECTROGRAM_MAX[1])
+    png = out_dir / rel.parent / f"{rel.name}.spectrogram.png"
+    src = png.with_name(png.name + ".src")
+    st = path.stat()
+    key = f"{st.st_size} {st.st_mtime_ns} {w}x{h}"
+    if png.exists() and src.exists() and src.read_text(encoding="utf-8") == key:
+        return png
+
+    png.parent.mkdir(parents=True, exist_ok=True)
+    cmd = [
+        ffmpeg_bin,
+        "-hide_banner",
+        "-nostats",
+        "-y",
+        "-i", str(path),
+        "-lavfi", f"showspectrumpic=s={w}x{h}",
+        str(png),
+    ]
+    rc, out, err = run(cmd)
+    if rc != 0 or not png.exists():
+        return None
+    src.write_text(key, encoding="utf-8")
+    return png
+
+def qc_stamp(r: "QCResult") -> str:
+    """One-line summary written into the comment tag of a tagged copy."""
+    status = "PASS" if r.passed else "FAIL"
+    return (f"QC {status} I={pretty(r.loudness.integrated_lufs)} LUFS "
+            f"TP={pretty(r.loudness.true_peak_db)} dBTP "
+            f"SP={pretty(r.signal.sample_peak_db)} dBFS "
+            f"LRA={pretty(r.loudness.loudness_range_lu)} LU")
+
+def ffmpeg_write_qc_tag(ffmpeg_bin: str, r: "QCResult", rel: Path, out_dir: Path) -> Optional[Path]:
+    """
+    Writes a stream copy of the file with qc_stamp() in its comment tag as
+    <stem>.qc<ext> at `rel` (its path relative to the scan root) under
+    out_dir, so same-named masters in different folders don't overwrite each
+    other. The original is never modified.
+    """
+    dst = out_dir / rel.parent / f"{rel.stem}.qc{rel.suffix}"
+    dst.parent.mkdir(parents=True, exist_ok=True)
+    cmd = [
+        ffmpeg_bin,
+        "-hide_banner",
+        "-nostats",
+        "-y",
+        "-i", str(r.path),
+        "-map", "0",
+        "-map_metadata", "0",
+        "-c", "copy",
+        "-metadata", f"comment={qc_stamp(r)}",
+        str(dst),
+    ]
+    rc, out, err = run(cmd)
+    return dst if rc == 0 and dst.exists() else None
+
 def ffprobe_embedded_artwork(ffprobe_bin: str, path: Path) -> ArtworkInfo:
     """
     Detects attached pictures / embedded artwork by scanning all streams.
@@ -287,9 +836,11 @@ def ffprobe_embedded_artwork(ffprobe_bin: str, path: Path) -> ArtworkInfo:
     ]
     rc, out, err = run(cmd)
     if rc != 0:
-        return ArtworkInfo(False, f"ffprobe artwork scan failed: {err.strip()}")
-
-    data = json.loads(out)
+        return ArtworkInfo(False, "artwork scan failed", error=tool_error(cmd, rc, err))
+    try:
+        data = json.loads(out)
+    except ValueError:
+        return ArtworkInfo(False, "artwork scan failed", error="ffprobe returned invalid JSON")
     streams = data.get("streams", []) or []
 
     hits = []
@@ -320,6 +871,45 @@ def ffprobe_embedded_artwork(ffprobe_bin: str, path: Path) -> ArtworkInfo:
 
     return ArtworkInfo(False, "No embedded artwork detected")
 
+def ffprobe_metadata(ffprobe_bin: str, path: Path, aliases: Dict[str, List[str]]) -> MetadataInfo:
+    """
+    Reads container and stream tags. For WAV, ffprobe surfaces the RIFF INFO
+    chunk (INAM/IART/IPRD/ICRD... as title/artist/album/date), the BWF bext
+    chunk (description, originator, origination_date, ...) and any id3 chunk
+    as format tags, so one probe covers all of them.
+
+    `aliases` maps a logical field to the tag names that satisfy it.
+    """
+    cmd = [
+        ffprobe_bin,
+        "-v", "error",
+        "-show_entries", "format_tags:stream_tags",
+        "-of", "json",
+        str(path),
+    ]
+    rc, out, err = run(cmd)
+    if rc != 0:
+        return MetadataInfo(tags={}, present={}, error=tool_error(cmd, rc, err))
+    try:
+        data = json.loads(out)
+    except ValueError:
+        return MetadataInfo(tags={}, present={}, error="ffprobe returned invalid JSON")
+
+    tags: Dict[str, str] = {}
+    sources = [(data.get("format") or {}).get("tags") or {}]
+    sources += [s.get("tags") or {} for s in (data.get("streams") or [])]
+    for src in sources:
+        for k, v in src.items():
+            if str(v).strip() != "":
+                tags.setdefault(k.lower(), str(v).strip())
+
+    present = {
+        field: any(name.lower() in tags for name in names)
+        for field, names in aliases.items()
+    }
+    isrc = next((tags[n.lower()] for n in aliases.get("isrc", ["isrc"]) if n.lower() in tags), None)
+    return MetadataInfo(tags=tags, present=present, isrc=isrc)
+
 
 # ----------------------------
 # Naming / master type detection
@@ -332,28 +922,33 @@ def detect_master_type_from_filename(name: str, allowed_types: List[str]) -> Opt
             return t
     return None
 
-def validate_naming(path: Path, naming_cfg: Dict[str, Any]) -> Tuple[bool, str]:
+def validate_naming(path: Path, naming_cfg: Dict[str, Any], extensions: List[str]) -> Tuple[bool, str]:
+    """
+    Checks every naming rule and reports all violations, not just the first,
+    so a rejected name can be fixed in one go.
+    """
     dash = naming_cfg.get("dash", " – ")
     catalog_regex = naming_cfg.get("catalog_regex", r"\([A-Z]+-\d+\)")
     allowed_types = naming_cfg.get("master_types", [])
     fname = path.name
+    problems: List[str] = []
 
-    if not fname.lower().endswith(".wav"):
-        return False, "Not a .wav file name"
+    if path.suffix.lower() not in extensions:
+        problems.append(f"Extension '{path.suffix}' not allowed (allowed: {', '.join(extensions)})")
 
     if dash not in fname:
-        return False, f"Missing required dash separator '{dash}'"
+        problems.append(f"Missing required dash separator '{dash}'")
 
     if not re.search(catalog_regex, fname):
-        return False, f"Missing/invalid catalog number (regex: {catalog_regex})"
-
-    mt = detect_master_type_from_filename(fname, allowed_types)
-    if mt is None:
-        return False, f"Missing/invalid master type tag (allowed: {', '.join(allowed_types)})"
+        problems.append(f"Missing/invalid catalog number (regex: {catalog_regex})")
 
     if "[" not in fname or "]" not in fname:
-        return False, "Missing [MASTER TYPE] brackets"
+        problems.append("Missing [MASTER TYPE] brackets")
+    elif detect_master_type_from_filename(fname, allowed_types) is None:
+        problems.append(f"Missing/invalid master type tag (allowed: {', '.join(allowed_types)})")
 
+    if problems:
+        return False, "; ".join(problems)
     return True, "OK"
 
 
@@ -361,38 +956,54 @@ def validate_naming(path: Path, naming_cfg: Dict[str, Any]) -> Tuple[bool, str]:
 # QC rules
 # ----------------------------
 
-def check_expected_audio(audio: AudioInfo, expected: Dict[str, Any]) -> List[Dict[str, Any]]:
+# Layout ffmpeg assumes for a channel count when the file doesn't say. Plain
+# WAVE_FORMAT_PCM WAVs (no channel mask) and AIFFs without a CHAN chunk
+# carry no layout, and ffprobe reports none (or "unknown" / "2 channels").
+DEFAULT_CHANNEL_LAYOUTS = {1: "mono", 2: "stereo"}
+
+def effective_channel_layout(audio: AudioInfo) -> Optional[str]:
+    reported = (audio.channel_layout or "").lower()
+    if reported and reported != "unknown" and not re.fullmatch(r"\d+ channels?", reported):
+        return reported
+    return DEFAULT_CHANNEL_LAYOUTS.get(audio.channels or 0)
+
+def check_expected_audio(audio: AudioInfo, expected: Dict[str, Any], formats: List[str], layout: Optional[str], rates: List[int]) -> List[Dict[str, Any]]:
     checks = []
 
+    # ffprobe may list aliases ("mov,mp4,m4a,..."); any allowed one counts.
+    probed = [f for f in (audio.format_name or "").lower().split(",") if f]
+    fmt = next((f for f in probed if f in formats), None)
+    ext = audio.path.suffix.lower()
     checks.append({
-        "id": "file_is_wav",
-        "pass": is_wav(audio.path),
-        "details": f"ext={audio.path.suffix.lower()} expected=.wav"
+        "id": "container_format",
+        "pass": fmt is not None and ext in FORMAT_EXTENSIONS.get(fmt, []),
+        "details": f"format={audio.format_name} ext={ext} allowed={', '.join(formats)}"
     })
 
-    codec_expected = expected.get("codec_contains", "pcm")
+    codec_expected = FORMAT_CODECS.get(fmt or "", expected.get("codec_contains", "pcm"))
     codec_ok = (audio.codec_name or "").lower().find(codec_expected.lower()) >= 0
     checks.append({
-        "id": "codec_pcm",
+        "id": "codec",
         "pass": codec_ok,
         "details": f"codec={audio.codec_name} expected_contains={codec_expected}"
     })
 
-    sr_expected = int(expected.get("sample_rate_hz", 48000))
-    sr_ok = (audio.sample_rate_hz == sr_expected)
+    sr_ok = (audio.sample_rate_hz in rates)
     checks.append({
-        "id": "sample_rate_48k",
+        "id": "sample_rate",
         "pass": sr_ok,
-        "details": f"sample_rate={audio.sample_rate_hz} expected={sr_expected}"
+        "details": f"sample_rate={audio.sample_rate_hz} expected={'/'.join(str(r) for r in rates)}"
     })
 
-    bd_expected = int(expected.get("bit_depth", 24))
-    bd_ok = (audio.bit_depth == bd_expected)
-    checks.append({
-        "id": "bit_depth_24",
-        "pass": bd_ok,
-        "details": f"bit_depth={audio.bit_depth} expected={bd_expected}"
-    })
+    if "bit_depth" in expected:
+        bd_expected = int(expected["bit_depth"])
+        bd_ok = (audio.bit_depth == bd_expected)
+        bd_seen = audio.bit_depth if audio.bit_depth is not None else "unknown bit depth"
+        checks.append({
+            "id": "bit_depth",
+            "pass": bd_ok,
+            "details": f"bit_depth={bd_seen} expected={bd_expected}"
+        })
 
     allowed = expected.get("channels_allowed", [2])
     ch_ok = (audio.channels in allowed)
@@ -402,6 +1013,20 @@ def check_expected_audio(audio: AudioInfo, expected: Dict[str, Any]) -> List[Dic
         "details": f"channels={audio.channels} allowed={allowed}"
     })
 
+    if layout:
+        got = effective_channel_layout(audio)
+        if got is None:
+            details = f"expected {layout}, got unknown layout ({pretty(audio.channels)} channels)"
+        elif got != (audio.channel_layout or "").lower():
+            details = f"expected {layout}, got {got} (no layout in file; default for {audio.channels} channels)"
+        else:
+            details = f"expected {layout}, got {got}"
+        checks.append({
+            "id": "channel_layout",
+            "pass": got == layout.lower(),
+            "details": details
+        })
+
     return checks
 
 def check_loudness(master_type: str, loud: LoudnessInfo, masters_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
@@ -440,6 +1065,31 @@ def check_loudness(master_type: str, loud: LoudnessInfo, masters_cfg: Dict[str,
             "details": f"I={pretty(I)} LUFS target={target} ±{tol}"
         })
 
+    # Optional dynamics bounds, e.g. to flag over-compressed vinyl premasters.
+    LRA = loud.loudness_range_lu
+    if "lra_min" in cfg or "lra_max" in cfg:
+        mn = float(cfg.get("lra_min", float("-inf")))
+        mx = float(cfg.get("lra_max", float("inf")))
+        ok = (LRA is not None) and (mn <= LRA <= mx)
+        checks.append({
+            "id": "loudness_range",
+            "pass": ok,
+            "details": f"LRA={pretty(LRA)} LU expected_range=[{mn},{mx}]"
+        })
+
+    for key, cid, label, val in (
+        ("momentary_max_lufs", "max_momentary_loudness", "M max", loud.max_momentary_lufs),
+        ("short_term_max_lufs", "max_short_term_loudness", "S max", loud.max_short_term_lufs),
+    ):
+        if key in cfg:
+            cap = float(cfg[key])
+            ok = (val is not None) and (val <= cap)
+            checks.append({
+                "id": cid,
+                "pass": ok,
+                "details": f"{label}={pretty(val)} LUFS max={cap}"
+            })
+
     if "true_peak_max_db" in cfg:
         tpmax = float(cfg["true_peak_max_db"])
         ok = (TP is not None) and (TP <= tpmax)
@@ -484,6 +1134,222 @@ def check_low_end_stereo(low: LowEndStereoInfo, low_cfg: Dict[str, Any]) -> List
     })
     return checks
 
+def normalization_preview(master_type: str, loud: LoudnessInfo, masters_cfg: Dict[str, Any]) -> Optional[NormalizationInfo]:
+    """
+    Gain needed to reach the master's loudness target (lufs_target, or the
+    middle of lufs_min..lufs_max) and the true peak that gain would produce.
+    Pure arithmetic on the measurements; nothing is re-analysed.
+    """
+    cfg = masters_cfg.get(master_type, {})
+    if "lufs_target" in cfg:
+        target = float(cfg["lufs_target"])
+    elif "lufs_min" in cfg and "lufs_max" in cfg:
+        target = (float(cfg["lufs_min"]) + float(cfg["lufs_max"])) / 2.0
+    else:
+        return None
+    if loud.integrated_lufs is None:
+        return None
+    gain = target - loud.integrated_lufs
+    return NormalizationInfo(
+        target_lufs=target,
+        gain_db=gain,
+        gain_linear=10 ** (gain / 20.0),
+        predicted_true_peak_db=None if loud.true_peak_db is None else loud.true_peak_db + gain,
+        true_peak_max_db=float(cfg["true_peak_max_db"]) if "true_peak_max_db" in cfg else None,
+    )
+
+def check_signal_stats(master_type: str, sig: SignalStats, masters_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
+    cfg = masters_cfg.get(master_type, {})
+    checks = []
+
+    if "max_clipped_samples" in cfg:
+        mx = int(cfg["max_clipped_samples"])
+        n = sig.clip_count
+        ok = (n is not None) and (n <= mx)
+        checks.append({
+            "id": "clipped_samples",
+            "pass": ok,
+            "details": f"{pretty(n)} full-scale clip events (max {mx}; a run of clipped samples counts once)"
+        })
+
+    # Some distributors specify sample peak rather than true peak; a file can
+    # pass the dBTP limit while still hitting a sample-peak ceiling.
+    if "sample_peak_max_db" in cfg:
+        mx = float(cfg["sample_peak_max_db"])
+        sp = sig.sample_peak_db
+        ok = (sp is not None) and (sp <= mx)
+        checks.append({
+            "id": "sample_peak_limit",
+            "pass": ok,
+            "details": f"sample peak={pretty(sp)} dBFS max={mx}"
+        })
+
+    if "dc_offset_max" in cfg:
+        mx = float(cfg["dc_offset_max"])
+        dc = sig.dc_offset
+        ok = (dc is not None) and (dc <= mx)
+        checks.append({
+            "id": "dc_offset",
+            "pass": ok,
+            "details": f"DC offset={'n/a' if dc is None else f'{dc:.6f}'} max={mx}"
+        })
+
+    return checks
+
+def describe_phase(corr: float) -> str:
+    if corr < 0:
+        return "L and R are partly out of phase and cancel when summed to mono; check polarity and stereo wideners"
+    if corr < 0.3:
+        return "L and R are largely uncorrelated (very wide image); expect level loss and hollow sound in mono"
+    return "L and R are well correlated"
+
+def check_stereo(master_type: str, stereo: StereoInfo, masters_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
+    cfg = masters_cfg.get(master_type, {})
+    checks = []
+
+    if "phase_correlation_min" in cfg:
+        mn = float(cfg["phase_correlation_min"])
+        corr = stereo.phase_correlation
+        if corr is None:
+            checks.append({
+                "id": "mono_compatibility",
+                "pass": False,
+                "details": "Could not measure L/R phase correlation"
+            })
+        else:
+            ok = corr >= mn
+            checks.append({
+                "id": "mono_compatibility",
+                "pass": ok,
+                "details": (
+                    f"{'' if ok else 'poor mono compatibility: '}"
+                    f"mean correlation={corr:.2f} worst={pretty(stereo.phase_correlation_min)} min={mn} "
+                    f"({describe_phase(corr)})"
+                )
+            })
+
+    return checks
+
+def detect_dual_mono(stereo: StereoInfo, signal: SignalStats, dual_cfg: Dict[str, Any]) -> Optional[bool]:
+    """
+    Dual-mono means L and R are (near) identical: phase correlation pinned at
+    +1 and matching channel levels. Reuses the aphasemeter and astats passes.
+    """
+    if stereo.phase_correlation is None or signal.rms_balance_db is None:
+        return None
+    return (stereo.phase_correlation >= float(dual_cfg.get("min_correlation", 0.999))
+            and signal.rms_balance_db <= float(dual_cfg.get("max_rms_diff_db", 0.1)))
+
+def check_dual_mono(stereo: StereoInfo, signal: SignalStats) -> List[Dict[str, Any]]:
+    if stereo.dual_mono is None:
+        return []
+    return [{
+        "id": "dual_mono",
+        "pass": not stereo.dual_mono,
+        "details": (
+            f"{'L and R are identical (dual-mono)' if stereo.dual_mono else 'true stereo'}: "
+            f"correlation={pretty(stereo.phase_correlation)} RMS difference={pretty(signal.rms_balance_db)} dB"
+        )
+    }]
+
+def check_silence(master_type: str, sil: SilenceInfo, masters_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
+    cfg = masters_cfg.get(master_type, {})
+    checks = []
+
+    for edge, measured in (("leading", sil.leading_ms), ("trailing", sil.trailing_ms)):
+        key = f"max_{edge}_silence_ms"
+        if key in cfg:
+            mx = float(cfg[key])
+            ok = (measured is not None) and (measured <= mx)
+            checks.append({
+                "id": f"{edge}_silence",
+                "pass": ok,
+                "details": f"{edge} silence={pretty(measured)} ms max={mx:g} ms"
+            })
+
+    return checks
+
+def fmt_duration(seconds: Optional[float]) -> str:
+    if seconds is None:
+        return "n/a"
+    return f"{int(seconds // 60)}:{seconds % 60:05.2f}"
+
+def check_duration(master_type: str, audio: AudioInfo, masters_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
+    cfg = masters_cfg.get(master_type, {})
+    checks = []
+
+    if "duration_min_s" in cfg or "duration_max_s" in cfg:
+        mn = cfg.get("duration_min_s")
+        mx = cfg.get("duration_max_s")
+        d = audio.duration_s
+        ok = d is not None and (mn is None or d >= float(mn)) and (mx is None or d <= float(mx))
+        checks.append({
+            "id": "duration",
+            "pass": ok,
+            "details": f"duration={fmt_duration(d)} min={fmt_duration(None if mn is None else float(mn))} max={fmt_duration(None if mx is None else float(mx))}"
+        })
+
+    return checks
+
+def detect_resample(bands: List[float], band_hz: float, sample_rate: int, resample_cfg: Dict[str, Any]) -> Optional[int]:
+    """
+    A file upsampled from a lower rate (e.g. 44.1 -> 48 kHz) carries the
+    original resampler's lowpass: a wall just below the lower rate's Nyquist
+    with nothing above it up to the file's own Nyquist. Returns the candidate
+    rate whose Nyquist region holds such a wall, or None.
+
+    Every recording rolls off near its own Nyquist, so only rates below the
+    file's are candidates, and walls at or above native_rolloff x the file's
+    rate are taken to be the file's own anti-alias filter. A 44.1 -> 48 ->
+    44.1 round trip is indistinguishable from a native 44.1 kHz file.
+    """
+    min_drop = float(resample_cfg.get("min_drop_db", 30.0))
+    window = float(resample_cfg.get("window", 0.85))
+    native_edge = float(resample_cfg.get("native_rolloff", 0.44)) * sample_rate
+    for rate in sorted(int(r) for r in resample_cfg.get("candidate_rates", [44100, 48000])):
+        if rate >= sample_rate:
+            continue
+        nyq = rate / 2
+        # find_spectral_cutoff requires the drop to hold for everything above
+        # the wall, so the gap up to the file's Nyquist must be empty.
+        wall = find_spectral_cutoff(bands, band_hz, window * nyq, min_drop)
+        if wall is not None and wall <= nyq and wall < native_edge:
+            return rate
+    return None
+
+def check_resample(spec: SpectrumInfo, resample_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
+    if not bool(resample_cfg.get("enabled", False)):
+        return []
+    if spec.suspected_resample:
+        details = f"suspected resample: resampler lowpass just below {spec.resample_from_hz / 2:.0f} Hz (source likely {spec.resample_from_hz} Hz)"
+    else:
+        details = "no resampler lowpass found"
+    return [{"id": "resample", "pass": not spec.suspected_resample, "details": details}]
+
+def check_lossy_source(spec: SpectrumInfo, lossy_cfg: Dict[str, Any]) -> List[Dict[str, Any]]:
+    if not bool(lossy_cfg.get("enabled", False)):
+        return []
+    below = float(lossy_cfg.get("suspect_below_hz", 19000))
+    if spec.suspected_lossy:
+        details = f"suspected lossy source: spectrum cuts off at ~{spec.cutoff_hz:.0f} Hz (below {below:.0f} Hz)"
+    elif spec.cutoff_hz is not None:
+        details = f"cutoff at ~{spec.cutoff_hz:.0f} Hz (above {below:.0f} Hz)"
+    else:
+        details = "no brick-wall cutoff found"
+    return [{"id": "lossy_source", "pass": not spec.suspected_lossy, "details": details}]
+
+def check_effective_bit_depth(audio: AudioInfo, sig: SignalStats) -> List[Dict[str, Any]]:
+    """Flags containers that declare more bits than the audio uses ("fake 24-bit")."""
+    declared, effective = audio.bit_depth, sig.effective_bit_depth
+    if declared is None or effective is None:
+        return []
+    return [{
+        "id": "effective_bit_depth",
+        "pass": effective >= declared,
+        "details": f"declared={declared} effective={effective}"
+                   + ("" if effective >= declared else f" (only {effective} bits of real audio in a {declared}-bit file)")
+    }]
+
 def check_artwork(art: ArtworkInfo, expected: Dict[str, Any]) -> List[Dict[str, Any]]:
     checks = []
     disallow = bool(expected.get("disallow_embedded_artwork", True))
@@ -498,6 +1364,121 @@ def check_artwork(art: ArtworkInfo, expected: Dict[str, Any]) -> List[Dict[str,
     })
     return checks
 
+def check_metadata(meta: MetadataInfo, required: List[str]) -> List[Dict[str, Any]]:
+    # A required field without aliases is matched by its own tag name.
+    missing = [f for f in required if not (meta.present.get(f) or f.lower() in meta.tags)]
+    return [{
+        "id": "metadata_required_tags",
+        "pass": not missing,
+        "details": f"missing={', '.join(missing)}" if missing else f"present={', '.join(required) or 'none required'}"
+    }]
+
+
+# Checks that report but never fail a file unless the config says otherwise.
+DEFAULT_ADVISORY_CHECKS = ["lossy_source", "resample", "effective_bit_depth", "release_loudness", "dual_mono"]
+
+# Check ids fed by each analysis, so a failed ffmpeg run is pinned on the
+# checks it affects rather than showing up as a bare "n/a" measurement.
+ANALYSIS_CHECKS = {
+    "loudness": ["integrated_lufs_range", "integrated_lufs_hard_ceiling", "integrated_lufs_target_band",
+                 "loudness_range", "max_momentary_loudness", "max_short_term_loudness", "true_peak_limit"],
+    "low_end": ["low_end_stereo"],
+    "signal": ["clipped_samples", "sample_peak_limit", "dc_offset"],
+    "stereo": ["mono_compatibility"],
+    "silence": ["leading_silence", "trailing_silence"],
+    "spectrum": ["lossy_source", "resample"],
+    "artwork": ["no_embedded_artwork"],
+    "metadata": ["metadata_required_tags", "isrc"],
+}
+
+def mark_errored_checks(checks: List[Dict[str, Any]], errors: Dict[str, str]) -> None:
+    """Fails and flags (errored=True) every check whose analysis could not run."""
+    for name, msg in errors.items():
+        ids = ANALYSIS_CHECKS.get(name, [])
+        for c in checks:
+            if c["id"] in ids:
+                c["pass"] = False
+                c["errored"] = True
+                c["details"] += f" [{name} analysis failed: {msg}]"
+
+def classify_checks(checks: List[Dict[str, Any]], advisory: List[str]) -> Tuple[bool, List[str]]:
+    """
+    Tags each check with severity "blocking" or "advisory". Returns whether
+    all blocking checks passed, plus the failed advisory checks as warnings.
+    """
+    warnings = []
+    passed = True
+    for c in checks:
+        c["severity"] = "advisory" if c["id"] in advisory else "blocking"
+        if c["pass"]:
+            continue
+        if c["severity"] == "advisory":
+            warnings.append(f"{c['id']}: {c['details']}")
+        else:
+            passed = False
+    return passed, warnings
+
+def advisory_for(config: Dict[str, Any], master_type: Optional[str]) -> List[str]:
+    default = config.get("advisory_checks", DEFAULT_ADVISORY_CHECKS)
+    return config["masters"].get(master_type or "", {}).get("advisory_checks", default)
+
+# CC-XXX-YY-NNNNN: country, registrant (alphanumeric), year, designation.
+# Hyphens are presentation only and optional in tags.
+ISRC_RE = re.compile(r"^[A-Z]{2}-?[A-Z0-9]{3}-?\d{2}-?\d{5}$")
+
+def check_isrc(meta: MetadataInfo, required: bool) -> List[Dict[str, Any]]:
+    """
+    ISRCs are normally assigned after QC approval, so a missing one only fails
+    when the master sets isrc_required; a malformed one always fails.
+    """
+    if meta.isrc is None:
+        return [{
+            "id": "isrc",
+            "pass": not required,
+            "details": "ISRC missing" + (" (required)" if required else " (not required at QC)")
+        }]
+    ok = ISRC_RE.match(meta.isrc.upper()) is not None
+    return [{
+        "id": "isrc",
+        "pass": ok,
+        "details": f"ISRC={meta.isrc}" + ("" if ok else " malformed (expected CC-XXX-YY-NNNNN)")
+    }]
+
+def release_loudness_groups(results: List["QCResult"]) -> Dict[str, List["QCResult"]]:
+    """Groups measured files by master type; a release is compared per master type."""
+    groups: Dict[str, List[QCResult]] = {}
+    for r in results:
+        if r.master_type and r.loudness.integrated_lufs is not None:
+            groups.setdefault(r.master_type, []).append(r)
+    return groups
+
+def median(values: List[float]) -> float:
+    v = sorted(values)
+    mid = len(v) // 2
+    return v[mid] if len(v) % 2 else (v[mid - 1] + v[mid]) / 2.0
+
+def apply_release_consistency(results: List["QCResult"], config: Dict[str, Any]) -> None:
+    """
+    Adds a release_loudness check to each file comparing its integrated
+    loudness with the median of all files of the same master type in
+    this run, then re-classifies the file. Groups of one file are skipped.
+    """
+    release_cfg = config.get("release", {})
+    if not release_cfg.get("enabled", True):
+        return
+    max_dev = float(release_cfg.get("max_lufs_deviation", 1.5))
+    for master_type, group in release_loudness_groups(results).items():
+        if len(group) < 2:
+            continue
+        mid = median([r.loudness.integrated_lufs for r in group])
+        for r in group:
+            dev = r.loudness.integrated_lufs - mid
+            r.checks.append({
+                "id": "release_loudness",
+                "pass": abs(dev) <= max_dev,
+                "details": f"{dev:+.2f} LU vs {master_type} release median {mid:.2f} LUFS (limit ±{max_dev:.2f} LU)"
+            })
+            r.passed, r.warnings = classify_checks(r.checks, advisory_for(config, r.master_type))
 
 # ----------------------------
 # Reporting
@@ -506,30 +1487,72 @@ def check_artwork(art: ArtworkInfo, expected: Dict[str, Any]) -> List[Dict[str,
 def write_markdown_report(results: List[QCResult], md_path: Path) -> None:
     lines = []
     lines.append("# Audio QC Report\n\n")
+    groups = {k: g for k, g in release_loudness_groups(results).items() if len(g) > 1}
+    if groups:
+        lines.append("## Release loudness\n\n")
+        for master_type, group in sorted(groups.items()):
+            values = [r.loudness.integrated_lufs for r in group]
+            lines.append(f"### {master_type}\n\n")
+            lines.append(f"Median **{median(values):.2f} LUFS**, spread **{max(values) - min(values):.2f} LU**\n\n")
+            lines.append("| File | Integrated LUFS |\n|---|---|\n")
+            for r in group:
+                lines.append(f"| {r.path.name} | {r.loudness.integrated_lufs:.2f} |\n")
+            lines.append("\n")
     for r in results:
         lines.append(f"## {r.path.name}\n\n")
         lines.append(f"- Master type: **{r.master_type or 'UNKNOWN'}**\n")
         lines.append(f"- Integrated LUFS: **{pretty(r.loudness.integrated_lufs)}**\n")
         lines.append(f"- True Peak (dBTP): **{pretty(r.loudness.true_peak_db)}**\n")
+        lines.append(f"- Sample Peak (dBFS): **{pretty(r.signal.sample_peak_db)}**\n")
+        n = r.normalization
+        if n is not None:
+            over = (n.predicted_true_peak_db is not None and n.true_peak_max_db is not None
+                    and n.predicted_true_peak_db > n.true_peak_max_db)
+            lines.append(
+                f"- Gain to {n.target_lufs:.1f} LUFS: **{n.gain_db:+.2f} dB** (×{n.gain_linear:.3f}), "
+                f"predicted TP **{pretty(n.predicted_true_peak_db)} dBTP**"
+                + (f" — would exceed the {n.true_peak_max_db} dBTP limit" if over else "") + "\n"
+            )
+        lines.append(f"- Loudness range (LU): **{pretty(r.loudness.loudness_range_lu)}**\n")
+        lines.append(f"- Max momentary / short-term (LUFS): **{pretty(r.loudness.max_momentary_lufs)} / {pretty(r.loudness.max_short_term_lufs)}**\n")
+        lines.append(f"- Duration: **{fmt_duration(r.audio.duration_s)}**\n")
         lines.append(f"- Sample rate: **{pretty(r.audio.sample_rate_hz)}** Hz\n")
-        lines.append(f"- Bit depth: **{pretty(r.audio.bit_depth)}**\n")
-        lines.append(f"- Channels: **{pretty(r.audio.channels)}**\n")
+        lines.append(f"- Bit depth (declared / effective): **{pretty(r.audio.bit_depth)} / {pretty(r.signal.effective_bit_depth)}**\n")
+        lines.append(f"- Channels: **{pretty(r.audio.channels)}** ({r.audio.channel_layout or 'unknown layout'})\n")
         lines.append(f"- Low-end Mid RMS (dB): **{pretty(r.low_end.mid_rms_db)}**\n")
         lines.append(f"- Low-end Side RMS (dB): **{pretty(r.low_end.side_rms_db)}**\n")
         lines.append(f"- Low-end Side-Mid (dB): **{pretty(r.low_end.side_minus_mid_db)}**\n")
+        lines.append(f"- Full-scale clip events: **{pretty(r.signal.clip_count)}**\n")
+        lines.append(f"- DC offset: **{'n/a' if r.signal.dc_offset is None else f'{r.signal.dc_offset:.6f}'}**\n")
+        lines.append(f"- Phase correlation (mean / worst): **{pretty(r.stereo.phase_correlation)} / {pretty(r.stereo.phase_correlation_min)}**"
+                     + (" (dual-mono)" if r.stereo.dual_mono else "") + "\n")
+        lines.append(f"- Leading / trailing silence (ms): **{pretty(r.silence.leading_ms)} / {pretty(r.silence.trailing_ms)}**\n")
         lines.append(f"- Embedded artwork: **{'YES' if r.artwork.has_embedded_artwork else 'NO'}**\n")
+        present = [k for k, v in r.metadata.present.items() if v]
+        lines.append(f"- Metadata present: **{', '.join(present) or 'none'}**\n")
+        if r.spectrogram is not None:
+            rel = os.path.relpath(r.spectrogram, md_path.parent)
+            lines.append(f"\n![spectrogram](<{Path(rel).as_posix()}>)\n")
+        for name, msg in r.analysis_errors.items():
+            lines.append(f"- ⚠️ {name} analysis failed: `{msg}`\n")
         lines.append("\n### Checks\n\n")
         for c in r.checks:
-            status = "✅ PASS" if c["pass"] else "❌ FAIL"
+            if c["pass"]:
+                status = "✅ PASS"
+            elif c.get("severity") == "advisory":
+                status = "⚠️ WARN"
+            else:
+                status = "❌ FAIL"
             lines.append(f"- {status} `{c['id']}` — {c['details']}\n")
         lines.append("\n")
     md_path.write_text("".join(lines), encoding="utf-8")
 
-def results_to_json(results: List[QCResult]) -> List[Dict[str, Any]]:
+def results_to_json(results: List[QCResult], tools: Dict[str, str]) -> List[Dict[str, Any]]:
     out = []
     for r in results:
         out.append({
             "file": str(r.path),
+            "tools": tools,
             "master_type": r.master_type,
             "passed": r.passed,
             "audio": {
@@ -538,21 +1561,63 @@ def results_to_json(results: List[QCResult]) -> List[Dict[str, Any]]:
                 "sample_rate_hz": r.audio.sample_rate_hz,
                 "bit_depth": r.audio.bit_depth,
                 "channels": r.audio.channels,
+                "channel_layout": r.audio.channel_layout,
                 "duration_s": r.audio.duration_s,
             },
             "loudness": {
                 "integrated_lufs": r.loudness.integrated_lufs,
                 "true_peak_db": r.loudness.true_peak_db,
+                "loudness_range_lu": r.loudness.loudness_range_lu,
+                "max_momentary_lufs": r.loudness.max_momentary_lufs,
+                "max_short_term_lufs": r.loudness.max_short_term_lufs,
             },
             "low_end": {
                 "mid_rms_db": r.low_end.mid_rms_db,
                 "side_rms_db": r.low_end.side_rms_db,
                 "side_minus_mid_db": r.low_end.side_minus_mid_db,
             },
+            "signal": {
+                "clip_count": r.signal.clip_count,
+                "dc_offset": r.signal.dc_offset,
+                "sample_peak_db": r.signal.sample_peak_db,
+                "effective_bit_depth": r.signal.effective_bit_depth,
+                "rms_balance_db": r.signal.rms_balance_db,
+            },
+            "stereo": {
+                "phase_correlation": r.stereo.phase_correlation,
+                "phase_correlation_min": r.stereo.phase_correlation_min,
+                "dual_mono": r.stereo.dual_mono,
+            },
+            "spectrum": {
+                "cutoff_hz": r.spectrum.cutoff_hz,
+                "suspected_lossy": r.spectrum.suspected_lossy,
+                "suspected_resample": r.spectrum.suspected_resample,
+                "resample_from_hz": r.spectrum.resample_from_hz,
+            },
+            "silence": {
+                "leading_ms": r.silence.leading_ms,
+                "trailing_ms": r.silence.trailing_ms,
+            },
             "artwork": {
                 "has_embedded_artwork": r.artwork.has_embedded_artwork,
                 "details": r.artwork.details,
             },
+            "metadata": {
+                "present": r.metadata.present,
+                "isrc": r.metadata.isrc,
+                "tags": r.metadata.tags,
+            },
+            "warnings": r.warnings,
+            "analysis_errors": r.analysis_errors,
+            "normalization": None if r.normalization is None else {
+                "target_lufs": r.normalization.target_lufs,
+                "gain_db": r.normalization.gain_db,
+                "gain_linear": r.normalization.gain_linear,
+                "predicted_true_peak_db": r.normalization.predicted_true_peak_db,
+                "true_peak_max_db": r.normalization.true_peak_max_db,
+            },
+            "spectrogram": str(r.spectrogram) if r.spectrogram else None,
+            "tagged_copy": str(r.tagged_copy) if r.tagged_copy else None,
             "checks": r.checks,
         })
     return out
@@ -562,113 +1627,390 @@ def results_to_json(results: List[QCResult]) -> List[Dict[str, Any]]:
 # Main
 # ----------------------------
 
-def cmd_qc(args: argparse.Namespace) -> int:
-    ffmpeg = which_or_die("ffmpeg")
-    ffprobe = which_or_die("ffprobe")
+def unanalysed_result(p: Path, master_type: Optional[str], audio: AudioInfo,
+                      checks: List[Dict[str, Any]], passed: bool, warnings: List[str]) -> QCResult:
+    """A result carrying only `checks`, for files whose analysis was not run."""
+    return QCResult(
+        path=p,
+        master_type=master_type,
+        audio=audio,
+        loudness=LoudnessInfo(),
+        low_end=LowEndStereoInfo(),
+        signal=SignalStats(),
+        stereo=StereoInfo(),
+        spectrum=SpectrumInfo(),
+        silence=SilenceInfo(),
+        artwork=ArtworkInfo(False, "not scanned"),
+        metadata=MetadataInfo(tags={}, present={}),
+        checks=checks,
+        passed=passed,
+        warnings=warnings,
+    )
+
+def unreadable_result(p: Path, master_type: Optional[str], reason: str) -> QCResult:
+    """A failed result for a file that can't be analysed at all."""
+    checks = [{"id": "readable", "pass": False, "details": reason, "severity": "blocking"}]
+    return unanalysed_result(p, master_type, AudioInfo(path=p), checks, False, [])
 
-    config = load_json(Path(args.config))
+def qc_file(p: Path, rel: Path, ffmpeg: str, ffprobe: str, config: Dict[str, Any],
+            forced_master_type: Optional[str], fail_fast: bool = False) -> QCResult:
+    """
+    Runs every probe and check for one file; `rel` is its path relative to
+    the scan root, used to name per-file outputs. Only reads shared state, so
+    cmd_qc can call it from several threads at once.
+
+    The format, naming and master-type checks only need ffprobe's header
+    probe. With fail_fast, a blocking failure among them returns before the
+    decoding analyses (loudness, stats, phase, silence, spectrum) run.
+    """
     expected = config["expected"]
     masters_cfg = config["masters"]
     naming_cfg = config.get("naming", {"strict": False})
     report_cfg = config.get("report", {})
     low_cfg = config.get("low_end_stereo", {"enabled": False})
+    meta_cfg = config.get("metadata", {})
+    lossy_cfg = config.get("lossy_source", {"enabled": False})
+    silence_cfg = config.get("silence", {})
+    resample_cfg = config.get("resample_detection", {"enabled": False})
+    dual_cfg = config.get("dual_mono", {})
+    tag_aliases = meta_cfg.get("tag_aliases", {})
+    allowed_types = naming_cfg.get("master_types", [])
+    strict_naming = bool(naming_cfg.get("strict", False))
+    cutoff = int(low_cfg.get("cutoff_hz", 120))
 
-    root = Path(args.path).resolve()
-    if not root.exists():
-        die(f"Path does not exist: {root}")
+    if forced_master_type:
+        master_type = forced_master_type.upper()
+    else:
+        master_type = detect_master_type_from_filename(p.name, allowed_types) if allowed_types else None
+    formats = allowed_formats(expected, masters_cfg.get(master_type or "", {}))
+
+    if p.stat().st_size == 0:
+        return unreadable_result(p, master_type, "empty file")
+    if sniff_container(p) is None:
+        return unreadable_result(p, master_type, "not a WAV, AIFF or FLAC file (unrecognised header)")
+    audio = ffprobe_audio_info(ffprobe, p)
+    if audio is None:
+        return unreadable_result(p, master_type, "unreadable or truncated audio")
+
+    layout = masters_cfg.get(master_type or "", {}).get("channel_layout", expected.get("channel_layout"))
+    rates = masters_cfg.get(master_type or "", {}).get(
+        "sample_rates_allowed", expected.get("sample_rates_allowed", [expected.get("sample_rate_hz", 48000)]))
+    format_checks = check_expected_audio(audio, expected, formats, layout, [int(r) for r in rates])
+
+    if strict_naming:
+        ok, msg = validate_naming(p, naming_cfg, extensions_for(formats))
+        naming_check = {"id": "naming_strict", "pass": ok, "details": msg}
+    else:
+        naming_check = {"id": "naming_strict", "pass": True, "details": "strict naming disabled"}
 
-    wavs: List[Path] = []
-    if root.is_dir():
-        wavs = sorted([p for p in root.rglob("*.wav")])
+    if master_type is None:
+        type_check = {"id": "master_type_detected", "pass": False, "details": "Could not detect [MASTER TYPE] from filename"}
+    elif master_type not in masters_cfg:
+        type_check = {"id": "master_type_detected", "pass": False, "details": f"No QC profile configured under 'masters' for {master_type}"}
     else:
-        wavs = [root]
+        type_check = {"id": "master_type_detected", "pass": True, "details": master_type}
+
+    if fail_fast:
+        cheap = format_checks + [naming_check, type_check]
+        passed, warnings = classify_checks(cheap, advisory_for(config, master_type))
+        if not passed:
+            return unanalysed_result(p, master_type, audio, cheap, passed, warnings)
+
+    loud = ffmpeg_loudness(ffmpeg, p)
+    # Disabled checks skip their probe, so a probe failure can't mark them errored.
+    low_end = ffmpeg_low_end_mid_side_rms(ffmpeg, p, cutoff_hz=cutoff) if low_cfg.get("enabled", False) else LowEndStereoInfo()
+    signal = ffmpeg_signal_stats(ffmpeg, p)
+    stereo = ffmpeg_phase_correlation(ffmpeg, p) if audio.channels == 2 else StereoInfo()
+    stereo.dual_mono = detect_dual_mono(stereo, signal, dual_cfg)
+
+    silence = ffmpeg_silence(ffmpeg, p, audio.duration_s, silence_cfg)
+
+    spectrum = SpectrumInfo()
+    lossy_on = bool(lossy_cfg.get("enabled", False))
+    resample_on = bool(resample_cfg.get("enabled", False))
+    if (lossy_on or resample_on) and audio.sample_rate_hz:
+        bands, band_hz = ffmpeg_band_spectrum(ffmpeg, p, audio.sample_rate_hz, audio.duration_s)
+        if not bands:
+            spectrum = SpectrumInfo(error="could not decode audio for spectrum analysis")
+        else:
+            wall = find_spectral_cutoff(bands, band_hz, float(lossy_cfg.get("search_from_hz", 14000)), float(lossy_cfg.get("min_drop_db", 30.0)))
+            source_rate = detect_resample(bands, band_hz, audio.sample_rate_hz, resample_cfg) if resample_on else None
+            spectrum = SpectrumInfo(
+                cutoff_hz=wall,
+                suspected_lossy=lossy_on and (wall is not None and wall < float(lossy_cfg.get("suspect_below_hz", 19000))),
+                suspected_resample=source_rate is not None,
+                resample_from_hz=source_rate,
+            )
+    spectrogram = None
+    if report_cfg.get("spectrogram_dir"):
+        size = report_cfg.get("spectrogram_size", [1920, 1080])
+        spectrogram = ffmpeg_spectrogram(ffmpeg, p, rel, Path(report_cfg["spectrogram_dir"]), size[0], size[1])
+
+    if expected.get("disallow_embedded_artwork", True):
+        art = ffprobe_embedded_artwork(ffprobe, p)
+    else:
+        art = ArtworkInfo(False, "not scanned")
+    meta = ffprobe_metadata(ffprobe, p, tag_aliases)
+
+    checks: List[Dict[str, Any]] = []
+    checks.extend(format_checks)
+    checks.extend(check_effective_bit_depth(audio, signal))
+    checks.extend(check_dual_mono(stereo, signal))
+    checks.extend(check_artwork(art, expected))
+    checks.extend(check_low_end_stereo(low_end, low_cfg))
+    checks.extend(check_lossy_source(spectrum, lossy_cfg))
+    checks.extend(check_resample(spectrum, resample_cfg))
+    checks.append(naming_check)
+    checks.append(type_check)
+
+    if type_check["pass"]:
+        checks.extend(check_loudness(master_type, loud, masters_cfg))
+        checks.extend(check_signal_stats(master_type, signal, masters_cfg))
+        checks.extend(check_stereo(master_type, stereo, masters_cfg))
+        checks.extend(check_silence(master_type, silence, masters_cfg))
+        checks.extend(check_duration(master_type, audio, masters_cfg))
+
+    # Masters may override the required tag list (e.g. vinyl premasters
+    # that are cut before tagging).
+    required_tags = masters_cfg.get(master_type or "", {}).get("required_tags", meta_cfg.get("required_tags", []))
+    checks.extend(check_metadata(meta, required_tags))
+    checks.extend(check_isrc(meta, bool(masters_cfg.get(master_type or "", {}).get("isrc_required", False))))
+
+    normalization = normalization_preview(master_type, loud, masters_cfg) if type_check["pass"] else None
+
+    analysis_errors = {
+        name: info.error
+        for name, info in (("loudness", loud), ("low_end", low_end), ("signal", signal),
+                           ("stereo", stereo), ("silence", silence), ("spectrum", spectrum),
+                           ("artwork", art), ("metadata", meta))
+        if info.error
+    }
+    mark_errored_checks(checks, analysis_errors)
+
+    passed, warnings = classify_checks(checks, advisory_for(config, master_type))
+    return QCResult(
+        path=p,
+        master_type=master_type,
+        audio=audio,
+        loudness=loud,
+        low_end=low_end,
+        signal=signal,
+        stereo=stereo,
+        spectrum=spectrum,
+        silence=silence,
+        artwork=art,
+        metadata=meta,
+        checks=checks,
+        passed=passed,
+        warnings=warnings,
+        spectrogram=spectrogram,
+        analysis_errors=analysis_errors,
+        normalization=normalization,
+    )
 
-    if not wavs:
-        die(f"No .wav files found under: {root}")
 
-    allowed_types = naming_cfg.get("master_types", [])
-    strict_naming = bool(naming_cfg.get("strict", False))
+def cmd_qc(args: argparse.Namespace) -> int:
+    global RUN_TIMEOUT_S
+    RUN_TIMEOUT_S = args.timeout if args.timeout > 0 else None
 
-    results: List[QCResult] = []
-    any_fail = False
+    ffmpeg = require_tool("ffmpeg")
+    ffprobe = require_tool("ffprobe")
+    tools = {"ffmpeg": tool_version(ffmpeg), "ffprobe": tool_version(ffprobe)}
 
-    cutoff = int(low_cfg.get("cutoff_hz", 120))
+    # With --json, stdout carries only the JSON document so it can be piped;
+    # the human-readable output moves to stderr.
+    log = sys.stderr if args.json else sys.stdout
+    print(f"Using ffmpeg {tools['ffmpeg']}, ffprobe {tools['ffprobe']}", file=log)
 
-    for p in wavs:
-        audio = ffprobe_audio_info(ffprobe, p)
-        loud = ffmpeg_loudness(ffmpeg, p)
-        low_end = ffmpeg_low_end_mid_side_rms(ffmpeg, p, cutoff_hz=cutoff)
-        art = ffprobe_embedded_artwork(ffprobe, p)
+    config = load_config(Path(args.config))
+    expected = config["expected"]
+    masters_cfg = config["masters"]
+    report_cfg = config.get("report", {})
 
-        checks: List[Dict[str, Any]] = []
-        checks.extend(check_expected_audio(audio, expected))
-        checks.extend(check_artwork(art, expected))
-        checks.extend(check_low_end_stereo(low_end, low_cfg))
+    if args.master_type and args.master_type.upper() not in masters_cfg:
+        raise InputError(f"Unknown --master-type '{args.master_type}'; configured: {', '.join(masters_cfg) or 'none'}")
 
-        master_type = detect_master_type_from_filename(p.name, allowed_types) if allowed_types else None
+    root = Path(args.path).resolve()
+    if not root.exists():
+        raise InputError(f"Path does not exist: {root}")
 
-        if strict_naming:
-            ok, msg = validate_naming(p, naming_cfg)
-            checks.append({"id": "naming_strict", "pass": ok, "details": msg})
-        else:
-            checks.append({"id": "naming_strict", "pass": True, "details": "strict naming disabled"})
+    # Pick up every extension any master may accept; per-master restrictions
+    # are enforced by the container_format check.
+    all_exts = set(extensions_for(allowed_formats(expected, {})))
+    for mcfg in masters_cfg.values():
+        all_exts.update(extensions_for(allowed_formats(expected, mcfg)))
 
-        if master_type is None:
-            checks.append({"id": "master_type_detected", "pass": False, "details": "Could not detect [MASTER TYPE] from filename"})
-        else:
-            checks.append({"id": "master_type_detected", "pass": True, "details": master_type})
-            checks.extend(check_loudness(master_type, loud, masters_cfg))
-
-        passed = all(c["pass"] for c in checks)
-        any_fail = any_fail or (not passed)
-
-        results.append(QCResult(
-            path=p,
-            master_type=master_type,
-            audio=audio,
-            loudness=loud,
-            low_end=low_end,
-            artwork=art,
-            checks=checks,
-            passed=passed,
-        ))
-
-    print("\n=== QC SUMMARY ===")
+    # Our own outputs may live under the scanned folder (tagged_copy_dir
+    # defaults to ./qc_tagged); never pick them up as masters.
+    output_dirs = [Path(report_cfg.get("tagged_copy_dir", "qc_tagged")).resolve()]
+    if report_cfg.get("spectrogram_dir"):
+        output_dirs.append(Path(report_cfg["spectrogram_dir"]).resolve())
+
+    files: List[Path] = []
+    if root.is_dir():
+        base = root
+        files = sorted([
+            p for p in root.rglob("*")
+            if p.is_file() and p.suffix.lower() in all_exts and not any(d in p.parents for d in output_dirs)
+        ])
+    else:
+        base = root.parent
+        files = [root]
+
+    if not files:
+        raise InputError(f"No {'/'.join(sorted(all_exts))} files found under: {root}")
+
+    # Each file is a run of ffmpeg/ffprobe subprocesses, so threads are
+    # enough to overlap them; results keep the sorted file order.
+    def qc_one(p: Path) -> QCResult:
+        return qc_file(p, p.relative_to(base), ffmpeg, ffprobe, config, args.master_type, args.fail_fast)
+
+    if args.jobs > 1:
+        with ThreadPoolExecutor(max_workers=args.jobs) as pool:
+            results = list(pool.map(qc_one, files))
+    else:
+        results = [qc_one(p) for p in files]
+    apply_release_consistency(results, config)
+
+    # Tagged copies carry the final verdict, so they are written only after
+    # release-level checks have run.
+    tagged_dir = Path(report_cfg.get("tagged_copy_dir", "qc_tagged"))
+    for r in results:
+        if masters_cfg.get(r.master_type or "", {}).get("write_qc_tag", False):
+            r.tagged_copy = ffmpeg_write_qc_tag(ffmpeg, r, r.path.relative_to(base), tagged_dir)
+            if r.tagged_copy is None:
+                print(f"WARNING: could not write tagged copy of {r.path.name}", file=log)
+    any_fail = any(not r.passed for r in results)
+
+    if args.json:
+        print(json.dumps(results_to_json(results, tools), indent=2))
+
+    print("\n=== QC SUMMARY ===", file=log)
     for r in results:
         status = "PASS" if r.passed else "FAIL"
         print(
             f"{status:4} | {r.path.name} | type={r.master_type or 'UNKNOWN'} | "
             f"I={pretty(r.loudness.integrated_lufs)} LUFS | TP={pretty(r.loudness.true_peak_db)} dBTP | "
+            f"LRA={pretty(r.loudness.loudness_range_lu)} LU | "
             f"low(side-mid)={pretty(r.low_end.side_minus_mid_db)} dB | art={'YES' if r.artwork.has_embedded_artwork else 'NO'}"
+            + (f" | warnings={len(r.warnings)}" if r.warnings else "")
+            + (f" | analysis errors={', '.join(r.analysis_errors)}" if r.analysis_errors else ""),
+            file=log,
         )
 
     json_path = Path(report_cfg.get("json_path", "qc_report.json"))
-    json_path.write_text(json.dumps(results_to_json(results), indent=2), encoding="utf-8")
-    print(f"\nWrote JSON report: {json_path}")
+    json_path.write_text(json.dumps(results_to_json(results, tools), indent=2), encoding="utf-8")
+    print(f"\nWrote JSON report: {json_path}", file=log)
 
     md_path_str = report_cfg.get("markdown_path")
     if md_path_str:
         md_path = Path(md_path_str)
         write_markdown_report(results, md_path)
-        print(f"Wrote Markdown report: {md_path}")
+        print(f"Wrote Markdown report: {md_path}", file=log)
 
     return 2 if any_fail else 0
 
+def delta(old: Optional[float], new: Optional[float]) -> str:
+    if old is None or new is None:
+        return f"{pretty(old)} -> {pretty(new)}"
+    return f"{old:.2f} -> {new:.2f} ({new - old:+.2f})"
+
+def load_report(path: Path) -> Dict[str, Dict[str, Any]]:
+    """
+    Reads a JSON report written by `qc`, keyed by each file's path relative
+    to the folder all its files share, so reports from different delivery
+    folders line up while same-named files in subfolders stay apart.
+    """
+    data = load_json(path)
+    if not isinstance(data, list) or not all(isinstance(r, dict) and "file" in r for r in data):
+        raise ConfigError(f"Not a QC report (expected a list of per-file results): {path}")
+    files = [Path(r["file"]) for r in data]
+    try:
+        root = Path(os.path.commonpath([str(f.parent) for f in files])) if files else Path()
+    except ValueError:  # mixed absolute/relative paths
+        root = Path()
+    out: Dict[str, Dict[str, Any]] = {}
+    for f, r in zip(files, data):
+        key = f.relative_to(root).as_posix() if root != Path() else f.as_posix()
+        if key in out:
+            raise ConfigError(f"Duplicate entry for {key} in report: {path}")
+        out[key] = r
+    return out
+
+def cmd_diff(args: argparse.Namespace) -> int:
+    """
+    Compares two JSON reports file by file (matched on relative path), e.g. the
+    runs before and after a revised master, and prints what changed. Blocks
+    missing from older reports or unreadable files compare as n/a.
+    """
+    old = load_report(Path(args.old))
+    new = load_report(Path(args.new))
+
+    def verdict(r: Dict[str, Any]) -> str:
+        return "PASS" if r.get("passed") else "FAIL"
+
+    for name in sorted(set(old) | set(new)):
+        if name not in new:
+            print(f"\n{name}: only in {args.old}")
+            continue
+        if name not in old:
+            print(f"\n{name}: only in {args.new}")
+            continue
+        a, b = old[name], new[name]
+        a_loud, b_loud = a.get("loudness") or {}, b.get("loudness") or {}
+        a_sig, b_sig = a.get("signal") or {}, b.get("signal") or {}
+        print(f"\n{name}: {verdict(a)} -> {verdict(b)}")
+        print(f"  Integrated LUFS:  {delta(a_loud.get('integrated_lufs'), b_loud.get('integrated_lufs'))}")
+        print(f"  True peak dBTP:   {delta(a_loud.get('true_peak_db'), b_loud.get('true_peak_db'))}")
+        print(f"  Sample peak dBFS: {delta(a_sig.get('sample_peak_db'), b_sig.get('sample_peak_db'))}")
+
+        a_checks = {c.get("id"): c for c in a.get("checks") or []}
+        b_checks = {c.get("id"): c for c in b.get("checks") or []}
+        label = {True: "pass", False: "fail", None: "absent"}
+        for cid in sorted(set(a_checks) | set(b_checks), key=str):
+            was = a_checks.get(cid, {}).get("pass")
+            now = b_checks.get(cid, {}).get("pass")
+            if was == now:
+                continue
+            details = b_checks.get(cid, a_checks.get(cid, {})).get("details", "")
+            print(f"  {cid}: {label.get(was, was)} -> {label.get(now, now)} ({details})")
+
+        a_meta = (a.get("metadata") or {}).get("present") or {}
+        b_meta = (b.get("metadata") or {}).get("present") or {}
+        for tag in sorted(set(a_meta) | set(b_meta)):
+            if bool(a_meta.get(tag)) != bool(b_meta.get(tag)):
+                print(f"  metadata {tag}: {'present' if a_meta.get(tag) else 'missing'} -> {'present' if b_meta.get(tag) else 'missing'}")
+    return 0
+
 def build_parser() -> argparse.ArgumentParser:
-    p = argparse.ArgumentParser(prog="qc_audio.py", description="Techno Label Audio QC")
+    p = QCArgumentParser(prog="qc_audio.py", description="Techno Label Audio QC")
     sub = p.add_subparsers(dest="cmd", required=True)
 
     qc = sub.add_parser("qc", help="Run QC on a file or directory")
-    qc.add_argument("path", help="Path to .wav file or directory")
+    qc.add_argument("path", help="Path to an audio file or directory")
     qc.add_argument("--config", default="qc_config.json", help="Path to qc_config.json")
+    qc.add_argument("--master-type", help="Apply this master profile to every file instead of reading [MASTER TYPE] from the filename")
+    qc.add_argument("--json", action="store_true", help="Print the JSON report to stdout (summary goes to stderr)")
+    qc.add_argument("--jobs", type=int, default=1, help="Number of files to analyse in parallel")
+    qc.add_argument("--fail-fast", action="store_true", help="Skip the decoding analyses for files that already fail a format, naming or master-type check")
+    qc.add_argument("--timeout", type=float, default=600.0, help="Seconds allowed per ffmpeg/ffprobe run (0 = no limit)")
     qc.set_defaults(func=cmd_qc)
 
+    diff = sub.add_parser("diff", help="Compare two JSON reports (e.g. before/after a revised master)")
+    diff.add_argument("old", help="Earlier qc_report.json")
+    diff.add_argument("new", help="Later qc_report.json")
+    diff.set_defaults(func=cmd_diff)
+
     return p
 
 def main() -> int:
     parser = build_parser()
     args = parser.parse_args()
-    return int(args.func(args))
+    try:
+        return int(args.func(args))
+    except QCError as e:
+        print(f"ERROR: {e}", file=sys.stderr)
+        return e.exit_code
 
 if __name__ == "__main__":
     raise SystemExit(main())